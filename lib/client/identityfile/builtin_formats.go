@@ -0,0 +1,169 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/gravitational/teleport/lib/sshutils"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// the files and the dir will be created with these permissions:
+	fileMode = 0600
+	dirMode  = 0700
+)
+
+func init() {
+	Register(fileFormatter{})
+	Register(opensshFormatter{})
+	Register(tlsFormatter{})
+	Register(pkcs12Formatter{})
+}
+
+// fileFormatter implements FormatFile: key, SSH cert, TLS cert, and trusted
+// CAs concatenated into a single file.
+type fileFormatter struct{ genericDecoder }
+
+func (fileFormatter) Name() Format { return FormatFile }
+
+func (fileFormatter) Write(w WriteContext) ([]string, error) {
+	f, err := os.OpenFile(w.FilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer f.Close()
+
+	// write key:
+	if _, err := f.Write(w.Key.Priv); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// append ssh cert:
+	if _, err := f.Write(w.Key.Cert); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// append tls cert:
+	if _, err := f.Write(w.Key.TLSCert); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// append trusted host certificate authorities
+	for _, ca := range w.CertAuthorities {
+		// append ssh ca certificates
+		for _, publicKey := range ca.GetCheckingKeys() {
+			data, err := sshutils.MarshalAuthorizedHostsFormat(ca.GetClusterName(), publicKey, nil)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			if _, err := f.Write([]byte(data)); err != nil {
+				return nil, trace.Wrap(err)
+			}
+			if _, err := f.Write([]byte("\n")); err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+		// append tls ca certificates
+		for _, keyPair := range ca.GetTLSKeyPairs() {
+			if _, err := f.Write(keyPair.Cert); err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+	}
+
+	return []string{w.FilePath}, nil
+}
+
+// opensshFormatter implements FormatOpenSSH: key and cert stored in two
+// separate files in the same directory.
+type opensshFormatter struct{ genericDecoder }
+
+func (opensshFormatter) Name() Format { return FormatOpenSSH }
+
+func (opensshFormatter) Write(w WriteContext) ([]string, error) {
+	keyPath := w.FilePath
+	certPath := keyPath + "-cert.pub"
+
+	if err := ioutil.WriteFile(certPath, w.Key.Cert, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := ioutil.WriteFile(keyPath, w.Key.Priv, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return []string{keyPath, certPath}, nil
+}
+
+// tlsFormatter implements FormatTLS: key, cert, and CA bundle stored in
+// three separate files, as expected by most TLS clients (e.g. gRPC).
+type tlsFormatter struct{ genericDecoder }
+
+func (tlsFormatter) Name() Format { return FormatTLS }
+
+func (tlsFormatter) Write(w WriteContext) ([]string, error) {
+	keyPath := w.FilePath + ".key"
+	certPath := w.FilePath + ".crt"
+	casPath := w.FilePath + ".cas"
+
+	if err := ioutil.WriteFile(certPath, w.Key.TLSCert, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := ioutil.WriteFile(keyPath, w.Key.Priv, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var caCerts []byte
+	for _, cert := range collectTLSCACerts(w.CertAuthorities) {
+		caCerts = append(caCerts, cert...)
+	}
+	if err := ioutil.WriteFile(casPath, caCerts, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return []string{keyPath, certPath, casPath}, nil
+}
+
+// pkcs12Formatter implements FormatPKCS12: a single password-protected
+// PKCS#12 file containing the private key, leaf TLS certificate, and
+// trusted TLS CA certificates.
+type pkcs12Formatter struct{ genericDecoder }
+
+func (pkcs12Formatter) Name() Format { return FormatPKCS12 }
+
+func (pkcs12Formatter) Write(w WriteContext) ([]string, error) {
+	p12Path := w.FilePath + ".p12"
+
+	password := w.Config.Password
+	if len(password) == 0 {
+		var err error
+		password, err = promptPKCS12Password()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	p12Bytes, err := encodePKCS12(w.Key, string(password), collectTLSCACerts(w.CertAuthorities))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := ioutil.WriteFile(p12Path, p12Bytes, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return []string{p12Path}, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gravitational/trace"
+)
+
+func init() {
+	Register(cockroachFormatter{})
+}
+
+// cockroachFormatter implements FormatCockroach: client.<user>.crt,
+// client.<user>.key, and ca.crt, named the way CockroachDB's
+// --certs-dir expects to find them.
+type cockroachFormatter struct{ genericDecoder }
+
+func (cockroachFormatter) Name() Format { return FormatCockroach }
+
+func (f cockroachFormatter) Write(w WriteContext) ([]string, error) {
+	user := w.Config.CockroachUser
+	if user == "" {
+		user = "root"
+	}
+
+	dir := filepath.Dir(w.FilePath)
+	certPath := filepath.Join(dir, "client."+user+".crt")
+	keyPath := filepath.Join(dir, "client."+user+".key")
+	caPath := filepath.Join(dir, "ca.crt")
+
+	if err := ioutil.WriteFile(certPath, w.Key.TLSCert, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := ioutil.WriteFile(keyPath, w.Key.Priv, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var caCerts []byte
+	for _, cert := range collectTLSCACerts(w.CertAuthorities) {
+		caCerts = append(caCerts, cert...)
+	}
+	if err := ioutil.WriteFile(caPath, caCerts, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	// Key must come first: a Watcher built on these paths (see NewWatcher)
+	// concatenates them in order and hands the result to Decode, which
+	// assigns the first PEM block it sees to PrivateKey and the second to
+	// Certs.TLS.
+	return []string{keyPath, certPath, caPath}, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gravitational/trace"
+)
+
+// checkCRL fetches cert's CRL distribution points and checks whether cert's
+// serial number appears in any of the revoked entries. It returns
+// (false, nil) if cert has no CRL distribution points.
+func checkCRL(ctx context.Context, client *http.Client, cert, issuer *x509.Certificate) (revoked bool, err error) {
+	for _, url := range cert.CRLDistributionPoints {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+
+		httpResp, err := client.Do(httpReq)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		body, err := ioutil.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+
+		crl, err := x509.ParseCRL(body)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		if err := issuer.CheckCRLSignature(crl); err != nil {
+			return false, trace.Wrap(err)
+		}
+
+		for _, revokedCert := range crl.TBSCertList.RevokedCertificates {
+			if revokedCert.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
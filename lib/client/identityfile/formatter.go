@@ -0,0 +1,126 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/teleport/lib/client"
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/gravitational/trace"
+)
+
+// WriteContext bundles the parameters a Formatter needs to render an
+// identity to disk.
+type WriteContext struct {
+	// FilePath is used as a base to generate output file names.
+	FilePath string
+	// Key holds the private key and certificates to write.
+	Key *client.Key
+	// CertAuthorities are the trusted CAs to bundle alongside the identity.
+	CertAuthorities []services.CertAuthority
+	// Config holds format-specific options, such as a PKCS#12 password or a
+	// Kubernetes proxy address.
+	Config WriteConfig
+}
+
+// Formatter produces and parses identity files in a particular on-disk
+// layout. Built-in formats (file, openssh, tls, pkcs12) as well as
+// third-party ones (kubernetes, mongo, cockroachdb) are all implemented as
+// Formatters and registered with Register, so downstream projects can add
+// their own identity layouts without patching Write.
+type Formatter interface {
+	// Name returns the Format this Formatter handles.
+	Name() Format
+	// Write renders an identity to disk and returns the paths written.
+	Write(w WriteContext) ([]string, error)
+	// Decode parses a previously written identity back into an IdentityFile.
+	Decode(r io.Reader) (*IdentityFile, error)
+}
+
+var formatters = make(map[Format]Formatter)
+
+// Register adds f to the set of formats Write and Lookup know how to
+// handle. Registering a Format that's already registered overwrites the
+// previous Formatter. Typically called from an init function.
+func Register(f Formatter) {
+	formatters[f.Name()] = f
+}
+
+// Lookup returns the Formatter registered for format, if any.
+func Lookup(format Format) (Formatter, bool) {
+	f, ok := formatters[format]
+	return f, ok
+}
+
+// DecodeFormat parses a previously written identity file back into an
+// IdentityFile using the Formatter registered for format, so a third-party
+// Formatter's custom Decode logic actually gets invoked for callers that
+// know which format they're reading (e.g. because they just wrote it, or
+// the user passed --format on the CLI). Callers that don't know the format
+// up front should use the package-level Decode, which auto-detects it
+// instead.
+func DecodeFormat(format Format, r io.Reader) (*IdentityFile, error) {
+	f, ok := Lookup(format)
+	if !ok {
+		return nil, trace.BadParameter("unsupported identity format: %q, use one of %q", format, registeredFormats())
+	}
+	ident, err := f.Decode(r)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return ident, nil
+}
+
+// registeredFormats returns the names of all registered formats, sorted,
+// for use in error messages.
+func registeredFormats() string {
+	names := make([]string, 0, len(formatters))
+	for format := range formatters {
+		names = append(names, string(format))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// genericDecoder implements the Decode half of Formatter by deferring to
+// the package-level Decode function, which auto-detects PEM/OpenSSH/PKCS#12
+// content regardless of how it was laid out on disk. It's embedded by
+// formatters whose Decode behavior doesn't need anything more specific.
+type genericDecoder struct{}
+
+func (genericDecoder) Decode(r io.Reader) (*IdentityFile, error) {
+	ident, err := Decode(r)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return ident, nil
+}
+
+// collectTLSCACerts flattens the TLS CA certificates out of certAuthorities.
+func collectTLSCACerts(certAuthorities []services.CertAuthority) [][]byte {
+	var caCerts [][]byte
+	for _, ca := range certAuthorities {
+		for _, keyPair := range ca.GetTLSKeyPairs() {
+			caCerts = append(caCerts, keyPair.Cert)
+		}
+	}
+	return caCerts
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// spyFormatter records whether its Decode method was actually invoked, to
+// verify that DecodeFormat (and anything built on it) reaches a
+// third-party Formatter's own decode logic rather than silently falling
+// back to the generic scanner.
+type spyFormatter struct {
+	decoded bool
+}
+
+func (f *spyFormatter) Name() Format { return "spy" }
+
+func (f *spyFormatter) Write(WriteContext) ([]string, error) {
+	return nil, nil
+}
+
+func (f *spyFormatter) Decode(r io.Reader) (*IdentityFile, error) {
+	f.decoded = true
+	return &IdentityFile{}, nil
+}
+
+func TestRegisterLookup(t *testing.T) {
+	if _, ok := Lookup(FormatFile); !ok {
+		t.Fatalf("expected %q to be registered by init()", FormatFile)
+	}
+
+	spy := &spyFormatter{}
+	Register(spy)
+	defer delete(formatters, spy.Name())
+
+	f, ok := Lookup(spy.Name())
+	if !ok || f != spy {
+		t.Fatalf("Lookup(%q) = %v, %v; want the registered spyFormatter", spy.Name(), f, ok)
+	}
+}
+
+func TestDecodeFormatInvokesFormatterDecode(t *testing.T) {
+	spy := &spyFormatter{}
+	Register(spy)
+	defer delete(formatters, spy.Name())
+
+	if _, err := DecodeFormat(spy.Name(), bytes.NewReader(nil)); err != nil {
+		t.Fatalf("DecodeFormat: %v", err)
+	}
+	if !spy.decoded {
+		t.Error("DecodeFormat did not call the registered Formatter's Decode method")
+	}
+}
+
+func TestDecodeFormatUnknownFormat(t *testing.T) {
+	if _, err := DecodeFormat("not-a-real-format", bytes.NewReader(nil)); err == nil {
+		t.Error("DecodeFormat succeeded for an unregistered format")
+	}
+}
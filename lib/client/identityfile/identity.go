@@ -20,15 +20,16 @@ package identityfile
 import (
 	"bufio"
 	"bytes"
+	"crypto/x509"
+	"encoding/pem"
 	"io"
-	"io/ioutil"
-	"os"
+	"time"
 
 	"github.com/gravitational/teleport/lib/client"
 	"github.com/gravitational/teleport/lib/services"
-	"github.com/gravitational/teleport/lib/sshutils"
 
 	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
 )
 
 // Format describes possible file formats how a user identity can be stored.
@@ -46,117 +47,73 @@ const (
 	// certificate and key are stored in separate files.
 	FormatTLS Format = "tls"
 
+	// FormatPKCS12 is a single password-protected PKCS#12 file containing the
+	// private key, the leaf TLS certificate, and the trusted TLS CA certificates.
+	// It is suitable for import into browsers, the macOS Keychain, the Windows
+	// Certificate Store, and Java keystores.
+	FormatPKCS12 Format = "pkcs12"
+
+	// FormatKubernetes produces a ready-to-use kubeconfig file for `kubectl`
+	// against the Teleport Kubernetes proxy.
+	FormatKubernetes Format = "kubernetes"
+
+	// FormatMongo produces a single PEM file containing the concatenated
+	// cert and key, as MongoDB's x.509 client auth expects.
+	FormatMongo Format = "mongo"
+
+	// FormatCockroach produces client.<user>.crt/.key and ca.crt files named
+	// the way CockroachDB's client connection flags expect.
+	FormatCockroach Format = "cockroachdb"
+
 	// DefaultFormat is what Teleport uses by default
 	DefaultFormat = FormatFile
 )
 
+// pkcs11URIScheme is the line prefix that marks an identity file's private
+// key as a PKCS#11 URI (RFC 7512) locating a key held on an HSM or token,
+// rather than PEM-encoded key material. See KeyStore and Signer.
+const pkcs11URIScheme = "pkcs11:"
+
+// WriteConfig holds additional, format-specific options for Write.
+type WriteConfig struct {
+	// Password protects the private key material in formats that support
+	// encryption (currently FormatPKCS12). If empty, the caller is prompted
+	// for one on stdin.
+	Password []byte
+
+	// KubeProxyAddr is the host:port of the Teleport Kubernetes proxy to
+	// embed as the cluster server in FormatKubernetes output.
+	KubeProxyAddr string
+
+	// CockroachUser names the SQL user the client cert/key are issued for,
+	// used to name the client.<user>.crt/.key files in FormatCockroach
+	// output. Defaults to "root" if empty.
+	CockroachUser string
+}
+
 // Write takes a username + their credentials and saves them to disk
 // in a specified format.
 //
 // filePath is used as a base to generate output file names; these names are
 // returned in filesWritten.
-func Write(filePath string, key *client.Key, format Format, certAuthorities []services.CertAuthority) (filesWritten []string, err error) {
-	const (
-		// the files and the dir will be created with these permissions:
-		fileMode = 0600
-		dirMode  = 0700
-	)
-
+func Write(filePath string, key *client.Key, format Format, certAuthorities []services.CertAuthority, cfg WriteConfig) (filesWritten []string, err error) {
 	if filePath == "" {
 		return nil, trace.BadParameter("identity location is not specified")
 	}
 
-	var output io.Writer = os.Stdout
-	switch format {
-	// dump user identity into a single file:
-	case FormatFile:
-		filesWritten = append(filesWritten, filePath)
-		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-		output = f
-		defer f.Close()
-
-		// write key:
-		if _, err = output.Write(key.Priv); err != nil {
-			return nil, trace.Wrap(err)
-		}
-		// append ssh cert:
-		if _, err = output.Write(key.Cert); err != nil {
-			return nil, trace.Wrap(err)
-		}
-		// append tls cert:
-		if _, err = output.Write(key.TLSCert); err != nil {
-			return nil, trace.Wrap(err)
-		}
-		// append trusted host certificate authorities
-		for _, ca := range certAuthorities {
-			// append ssh ca certificates
-			for _, publicKey := range ca.GetCheckingKeys() {
-				data, err := sshutils.MarshalAuthorizedHostsFormat(ca.GetClusterName(), publicKey, nil)
-				if err != nil {
-					return nil, trace.Wrap(err)
-				}
-				if _, err = output.Write([]byte(data)); err != nil {
-					return nil, trace.Wrap(err)
-				}
-				if _, err = output.Write([]byte("\n")); err != nil {
-					return nil, trace.Wrap(err)
-				}
-			}
-			// append tls ca certificates
-			for _, keyPair := range ca.GetTLSKeyPairs() {
-				if _, err = output.Write(keyPair.Cert); err != nil {
-					return nil, trace.Wrap(err)
-				}
-			}
-		}
-
-	// dump user identity into separate files:
-	case FormatOpenSSH:
-		keyPath := filePath
-		certPath := keyPath + "-cert.pub"
-		filesWritten = append(filesWritten, keyPath, certPath)
-
-		err = ioutil.WriteFile(certPath, key.Cert, fileMode)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-
-		err = ioutil.WriteFile(keyPath, key.Priv, fileMode)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-
-	case FormatTLS:
-		keyPath := filePath + ".key"
-		certPath := filePath + ".crt"
-		casPath := filePath + ".cas"
-		filesWritten = append(filesWritten, keyPath, certPath, casPath)
-
-		err = ioutil.WriteFile(certPath, key.TLSCert, fileMode)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
+	f, ok := Lookup(format)
+	if !ok {
+		return nil, trace.BadParameter("unsupported identity format: %q, use one of %q", format, registeredFormats())
+	}
 
-		err = ioutil.WriteFile(keyPath, key.Priv, fileMode)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-		var caCerts []byte
-		for _, ca := range certAuthorities {
-			for _, keyPair := range ca.GetTLSKeyPairs() {
-				caCerts = append(caCerts, keyPair.Cert...)
-			}
-		}
-		err = ioutil.WriteFile(casPath, caCerts, fileMode)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-	default:
-		return nil, trace.BadParameter("unsupported identity format: %q, use one of %q, %q, or %q",
-			format, FormatFile, FormatOpenSSH, FormatTLS)
+	filesWritten, err = f.Write(WriteContext{
+		FilePath:        filePath,
+		Key:             key,
+		CertAuthorities: certAuthorities,
+		Config:          cfg,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
 	}
 	return filesWritten, nil
 }
@@ -174,10 +131,41 @@ type IdentityFile struct {
 	}
 }
 
+// ExpiresAt returns the time at which this identity stops being valid,
+// computed as the earlier of the leaf TLS certificate's NotAfter and the
+// SSH certificate's ValidBefore. Teleport-issued certs are short-lived, so
+// callers that hold on to an IdentityFile for a while (e.g. a long-running
+// gRPC client) should use this to know when to re-issue.
+func (id *IdentityFile) ExpiresAt() time.Time {
+	var expiry time.Time
+
+	if block, _ := pem.Decode(id.Certs.TLS); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			expiry = cert.NotAfter
+		}
+	}
+
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey(id.Certs.SSH); err == nil {
+		if cert, ok := pub.(*ssh.Certificate); ok && cert.ValidBefore != ssh.CertTimeInfinity {
+			validBefore := time.Unix(int64(cert.ValidBefore), 0)
+			if expiry.IsZero() || validBefore.Before(expiry) {
+				expiry = validBefore
+			}
+		}
+	}
+
+	return expiry
+}
+
 // Decode attempts to break up the contents of an identity file
 // into its respective components.
 func Decode(r io.Reader) (*IdentityFile, error) {
-	scanner := bufio.NewScanner(r)
+	buf := bufio.NewReader(r)
+	if isPKCS12(buf) {
+		return decodePKCS12(buf)
+	}
+
+	scanner := bufio.NewScanner(buf)
 	var ident IdentityFile
 	// Subslice of scanner's buffer pointing to current line
 	// with leading and trailing whitespace trimmed.
@@ -209,6 +197,11 @@ func Decode(r io.Reader) (*IdentityFile, error) {
 			ident.Certs.SSH = cloneln()
 		case peekln("@cert-authority"):
 			ident.CACerts.SSH = append(ident.CACerts.SSH, cloneln())
+		case peekln(pkcs11URIScheme):
+			// The private key never left its HSM/token; this line is a
+			// locator URI rather than PEM-encoded key material. See
+			// Signer() for how it's resolved back into a crypto.Signer.
+			ident.PrivateKey = cloneln()
 		case peekln("-----BEGIN"):
 			// Current line marks the beginning of a PEM block.  Consume all
 			// lines until a corresponding END is found.
@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func selfSignedTLSCert(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	return marshalPEMCertificate(cert)
+}
+
+func sshCertWithValidBefore(t *testing.T, validBefore time.Time) []byte {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("building CA signer: %v", err)
+	}
+
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+	pub, err := ssh.NewPublicKey(&hostKey.PublicKey)
+	if err != nil {
+		t.Fatalf("building public key: %v", err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("signing certificate: %v", err)
+	}
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+func TestExpiresAt(t *testing.T) {
+	tlsExpiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	sshExpiry := time.Date(2029, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	ident := &IdentityFile{}
+	ident.Certs.TLS = selfSignedTLSCert(t, tlsExpiry)
+	ident.Certs.SSH = sshCertWithValidBefore(t, sshExpiry)
+
+	got := ident.ExpiresAt()
+	if !got.Equal(sshExpiry) {
+		t.Errorf("ExpiresAt() = %v, want the earlier SSH expiry %v", got, sshExpiry)
+	}
+}
+
+func TestExpiresAtTLSOnly(t *testing.T) {
+	tlsExpiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ident := &IdentityFile{}
+	ident.Certs.TLS = selfSignedTLSCert(t, tlsExpiry)
+
+	got := ident.ExpiresAt()
+	if !got.Equal(tlsExpiry) {
+		t.Errorf("ExpiresAt() = %v, want %v", got, tlsExpiry)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+
+	"github.com/gravitational/trace"
+)
+
+// KeyStore generates private keys that back an identity's SSH and TLS
+// certificates. Generate returns a crypto.Signer usable to produce a
+// certificate signing request, plus a locator to store as the identity's
+// private key in place of the raw key: for SoftKeyStore this is the
+// PEM-encoded key itself; for a hardware-backed KeyStore (see
+// PKCS11KeyStore) it's a URI that Decode can later resolve back into a
+// crypto.Signer bound to the token, so the private key itself never
+// touches disk.
+//
+// Callers request a cert against the Signer's public key, then set
+// client.Key.Priv to the returned locator before calling Write, the same
+// way Key.Cert/Key.TLSCert are already populated before Write is called.
+// Write and the built-in formatters treat Key.Priv as opaque bytes, so a
+// PKCS#11 locator flows through to the identity file unchanged, and
+// Signer() resolves it back on read.
+type KeyStore interface {
+	// Generate creates a new private key and returns a Signer for it along
+	// with the bytes Write should persist as the identity file's key
+	// material.
+	Generate() (crypto.Signer, []byte, error)
+}
+
+// SoftKeyStore is the default KeyStore: it generates an ordinary in-memory
+// ECDSA key and persists it as PEM, exactly as Teleport has always done.
+type SoftKeyStore struct{}
+
+// Generate implements KeyStore.
+func (SoftKeyStore) Generate() (crypto.Signer, []byte, error) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	keyPEM, err := marshalPEMPrivateKey(signer)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	return signer, keyPEM, nil
+}
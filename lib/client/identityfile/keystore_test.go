@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/teleport/lib/client"
+)
+
+func TestSoftKeyStoreGenerate(t *testing.T) {
+	signer, keyPEM, err := SoftKeyStore{}.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if signer == nil {
+		t.Fatal("Generate returned a nil Signer")
+	}
+
+	parsed, err := parsePEMPrivateKey(keyPEM)
+	if err != nil {
+		t.Fatalf("parsing returned key bytes as PEM: %v", err)
+	}
+	signerPub := signer.Public().(*ecdsa.PublicKey)
+	parsedPub := parsed.Public().(*ecdsa.PublicKey)
+	if signerPub.X.Cmp(parsedPub.X) != 0 || signerPub.Y.Cmp(parsedPub.Y) != 0 {
+		t.Error("Signer and the persisted key bytes don't agree on the public key")
+	}
+}
+
+// TestFileFormatterWritePassesThroughPKCS11Locator verifies that a
+// PKCS#11 locator placed in Key.Priv (by a caller that has already called
+// KeyStore.Generate() and gotten a cert issued against its public key)
+// reaches the identity file byte-for-byte, rather than Write generating a
+// fresh key of its own that wouldn't match the already-issued cert.
+func TestFileFormatterWritePassesThroughPKCS11Locator(t *testing.T) {
+	locator := []byte(pkcs11URIScheme + "token=yubikey;object=teleport;pin-source=env:PIN")
+
+	filePath := filepath.Join(t.TempDir(), "identity")
+	w := WriteContext{
+		FilePath: filePath,
+		Key:      &client.Key{Priv: locator},
+	}
+
+	if _, err := (fileFormatter{}).Write(w); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("reading %q: %v", filePath, err)
+	}
+	if !bytes.Contains(data, locator) {
+		t.Errorf("written file does not contain the PKCS#11 locator %q unchanged", locator)
+	}
+}
+
+func TestResolvePIN(t *testing.T) {
+	const envVar = "TEST_TELEPORT_PKCS11_PIN"
+	os.Setenv(envVar, "1234")
+	defer os.Unsetenv(envVar)
+
+	pin, err := resolvePIN("env:" + envVar)
+	if err != nil {
+		t.Fatalf("resolvePIN: %v", err)
+	}
+	if pin != "1234" {
+		t.Errorf("resolvePIN = %q, want %q", pin, "1234")
+	}
+}
+
+func TestResolvePINUnsetEnvVar(t *testing.T) {
+	os.Unsetenv("TEST_TELEPORT_PKCS11_PIN_UNSET")
+	if _, err := resolvePIN("env:TEST_TELEPORT_PKCS11_PIN_UNSET"); err == nil {
+		t.Fatal("expected an error for an unset PIN environment variable")
+	}
+}
+
+func TestResolvePINUnsupportedSource(t *testing.T) {
+	if _, err := resolvePIN("file:/tmp/pin"); err == nil {
+		t.Fatal("expected an error for an unsupported pin-source scheme")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/gravitational/trace"
+)
+
+func init() {
+	Register(kubeconfigFormatter{})
+}
+
+// kubeconfigFormatter implements FormatKubernetes, producing a standalone
+// kubeconfig file that authenticates `kubectl` to a cluster behind the
+// Teleport Kubernetes proxy using the issued client cert and key. It does
+// not embed genericDecoder: a kubeconfig is a YAML document with
+// base64-encoded fields, not the PEM/SSH-line format genericDecoder's
+// scanner understands, so Decode errors out rather than silently
+// returning an empty IdentityFile.
+type kubeconfigFormatter struct{}
+
+func (kubeconfigFormatter) Name() Format { return FormatKubernetes }
+
+// Decode implements Formatter. FormatKubernetes is write-only: it's meant
+// to be consumed by kubectl, not read back into an IdentityFile, so there
+// is nothing to decode.
+func (kubeconfigFormatter) Decode(io.Reader) (*IdentityFile, error) {
+	return nil, trace.BadParameter("%q identity files are write-only and cannot be decoded", FormatKubernetes)
+}
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: teleport
+  cluster:
+    server: https://%s
+    certificate-authority-data: %s
+contexts:
+- name: teleport
+  context:
+    cluster: teleport
+    user: teleport
+current-context: teleport
+users:
+- name: teleport
+  user:
+    client-certificate-data: %s
+    client-key-data: %s
+`
+
+func (f kubeconfigFormatter) Write(w WriteContext) ([]string, error) {
+	if w.Config.KubeProxyAddr == "" {
+		return nil, trace.BadParameter("KubeProxyAddr is required to write a %q identity file", FormatKubernetes)
+	}
+
+	var caCerts []byte
+	for _, cert := range collectTLSCACerts(w.CertAuthorities) {
+		caCerts = append(caCerts, cert...)
+	}
+
+	kubeconfig := fmt.Sprintf(kubeconfigTemplate,
+		w.Config.KubeProxyAddr,
+		base64.StdEncoding.EncodeToString(caCerts),
+		base64.StdEncoding.EncodeToString(w.Key.TLSCert),
+		base64.StdEncoding.EncodeToString(w.Key.Priv),
+	)
+
+	kubeconfigPath := w.FilePath + ".kubeconfig"
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig), fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return []string{kubeconfigPath}, nil
+}
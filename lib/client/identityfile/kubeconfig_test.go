@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/teleport/lib/client"
+)
+
+func TestKubeconfigFormatterWrite(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "identity")
+	w := WriteContext{
+		FilePath: filePath,
+		Key: &client.Key{
+			Priv:    []byte("key"),
+			TLSCert: []byte("cert"),
+		},
+		Config: WriteConfig{KubeProxyAddr: "proxy.example.com:3026"},
+	}
+
+	paths, err := kubeconfigFormatter{}.Write(w)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	kubeconfigPath := filePath + ".kubeconfig"
+	if len(paths) != 1 || paths[0] != kubeconfigPath {
+		t.Fatalf("Write returned %v, want [%q]", paths, kubeconfigPath)
+	}
+	if _, err := ioutil.ReadFile(kubeconfigPath); err != nil {
+		t.Fatalf("reading %q: %v", kubeconfigPath, err)
+	}
+}
+
+func TestKubeconfigFormatterWriteRequiresKubeProxyAddr(t *testing.T) {
+	w := WriteContext{
+		FilePath: filepath.Join(t.TempDir(), "identity"),
+		Key:      &client.Key{Priv: []byte("key"), TLSCert: []byte("cert")},
+	}
+
+	if _, err := (kubeconfigFormatter{}).Write(w); err == nil {
+		t.Fatal("expected an error when KubeProxyAddr is not set")
+	}
+}
+
+func TestKubeconfigFormatterDecodeIsUnsupported(t *testing.T) {
+	if _, err := (kubeconfigFormatter{}).Decode(bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected Decode to error out, FormatKubernetes is write-only")
+	}
+}
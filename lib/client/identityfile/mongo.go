@@ -0,0 +1,56 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"io/ioutil"
+
+	"github.com/gravitational/trace"
+)
+
+func init() {
+	Register(mongoFormatter{})
+}
+
+// mongoFormatter implements FormatMongo: the cert and key concatenated into
+// a single PEM file, plus a CA file, as the MongoDB shell and drivers expect
+// for x.509 client authentication (--tlsCertificateKeyFile / --tlsCAFile).
+type mongoFormatter struct{ genericDecoder }
+
+func (mongoFormatter) Name() Format { return FormatMongo }
+
+func (mongoFormatter) Write(w WriteContext) ([]string, error) {
+	certKeyPath := w.FilePath + ".crt"
+	casPath := w.FilePath + ".cas"
+
+	// Key must come first: Decode (which genericDecoder defers to) assigns
+	// the first PEM block it sees to PrivateKey and the second to Certs.TLS.
+	certKey := append(append([]byte{}, w.Key.Priv...), w.Key.TLSCert...)
+	if err := ioutil.WriteFile(certKeyPath, certKey, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var caCerts []byte
+	for _, cert := range collectTLSCACerts(w.CertAuthorities) {
+		caCerts = append(caCerts, cert...)
+	}
+	if err := ioutil.WriteFile(casPath, caCerts, fileMode); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return []string{certKeyPath, casPath}, nil
+}
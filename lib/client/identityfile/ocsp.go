@@ -0,0 +1,96 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspCache memoizes OCSP responses keyed on (issuer, serial number),
+// honoring each response's NextUpdate so repeated Verify calls don't hammer
+// the responder for certs whose status was just checked. Keying on the
+// issuer as well as the serial avoids serving a cached status for the
+// wrong CA's certificate: two different CAs can legitimately issue certs
+// with colliding serial numbers.
+var ocspCache sync.Map // ocspCacheKey -> *ocsp.Response
+
+// ocspCacheKey identifies a cached OCSP response by the issuer that signed
+// the leaf (hashed, since issuer certs can be arbitrarily large) and the
+// leaf's serial number.
+func ocspCacheKey(cert, issuer *x509.Certificate) string {
+	sum := sha256.Sum256(issuer.Raw)
+	return hex.EncodeToString(sum[:]) + ":" + cert.SerialNumber.String()
+}
+
+// checkOCSP consults cert's OCSP responder (from its AIA extension) for its
+// revocation status. checked is false if cert has no OCSP responder, in
+// which case the caller should fall back to CRL checking.
+func checkOCSP(ctx context.Context, client *http.Client, cert, issuer *x509.Certificate) (revoked bool, checked bool, err error) {
+	if len(cert.OCSPServer) == 0 {
+		return false, false, nil
+	}
+
+	key := ocspCacheKey(cert, issuer)
+	if cached, ok := ocspCache.Load(key); ok {
+		resp := cached.(*ocsp.Response)
+		if time.Now().Before(resp.NextUpdate) {
+			return resp.Status == ocsp.Revoked, true, nil
+		}
+		ocspCache.Delete(key)
+	}
+
+	reqBytes, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return false, false, trace.Wrap(err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cert.OCSPServer[0], bytes.NewReader(reqBytes))
+	if err != nil {
+		return false, false, trace.Wrap(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return false, false, trace.Wrap(err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return false, false, trace.Wrap(err)
+	}
+
+	resp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return false, false, trace.Wrap(err)
+	}
+
+	ocspCache.Store(key, resp)
+	return resp.Status == ocsp.Revoked, true, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"context"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPCacheKeyDiffersByIssuer(t *testing.T) {
+	cert := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	issuerA := &x509.Certificate{Raw: []byte("issuer-a")}
+	issuerB := &x509.Certificate{Raw: []byte("issuer-b")}
+
+	if ocspCacheKey(cert, issuerA) == ocspCacheKey(cert, issuerB) {
+		t.Error("expected different cache keys for the same serial under different issuers")
+	}
+}
+
+func TestCheckOCSPServesFreshCacheEntryWithoutNetwork(t *testing.T) {
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		OCSPServer:   []string{"http://127.0.0.1:1/ocsp"}, // never actually dialed
+	}
+	issuer := &x509.Certificate{Raw: []byte("test-issuer")}
+	key := ocspCacheKey(cert, issuer)
+
+	ocspCache.Store(key, &ocsp.Response{Status: ocsp.Good, NextUpdate: time.Now().Add(time.Hour)})
+	defer ocspCache.Delete(key)
+
+	revoked, checked, err := checkOCSP(context.Background(), http.DefaultClient, cert, issuer)
+	if err != nil {
+		t.Fatalf("checkOCSP: %v", err)
+	}
+	if !checked {
+		t.Fatal("checkOCSP reported checked=false for a fresh cache entry")
+	}
+	if revoked {
+		t.Error("checkOCSP reported revoked=true for a Good cached response")
+	}
+}
+
+func TestCheckOCSPIgnoresStaleCacheEntry(t *testing.T) {
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(43),
+		OCSPServer:   []string{"http://127.0.0.1:1/ocsp"}, // never dialed: building the request fails first for this stub issuer
+	}
+	issuer := &x509.Certificate{Raw: []byte("test-issuer")}
+	key := ocspCacheKey(cert, issuer)
+
+	ocspCache.Store(key, &ocsp.Response{Status: ocsp.Good, NextUpdate: time.Now().Add(-time.Hour)})
+	defer ocspCache.Delete(key)
+
+	_, checked, err := checkOCSP(context.Background(), &http.Client{Timeout: 2 * time.Second}, cert, issuer)
+	if err == nil {
+		t.Fatal("expected an error when the stale cache entry forces a fresh OCSP lookup")
+	}
+	if checked {
+		t.Error("checkOCSP reported checked=true despite the request failing")
+	}
+}
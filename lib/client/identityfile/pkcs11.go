@@ -0,0 +1,350 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11ModulePath locates the PKCS#11 provider (.so/.dll) used to resolve
+// "pkcs11:" key locators back into a crypto.Signer, e.g.
+// /usr/lib/x86_64-linux-gnu/libykcs11.so for a YubiKey, or SoftHSM's/
+// Nitrokey's equivalents. It defaults to the PKCS11_MODULE_PATH environment
+// variable and must be set before calling Signer on an IdentityFile whose
+// private key is a PKCS#11 URI.
+var PKCS11ModulePath = os.Getenv("PKCS11_MODULE_PATH")
+
+// PKCS11KeyStore is a KeyStore backed by a PKCS#11 token such as a YubiKey,
+// SoftHSM, or Nitrokey. Generate creates the key pair inside the token;
+// the private key material never leaves it and never touches disk. Only a
+// locator URI of the form "pkcs11:token=<TokenLabel>;object=<ObjectLabel>;
+// pin-source=<PINSource>" is returned for Write to persist.
+type PKCS11KeyStore struct {
+	// ModulePath is the PKCS#11 provider to load. Defaults to
+	// PKCS11ModulePath if empty.
+	ModulePath string
+	// TokenLabel identifies the token/slot to generate the key pair on.
+	TokenLabel string
+	// ObjectLabel is the label assigned to the generated key pair, used to
+	// look it up again later.
+	ObjectLabel string
+	// PINSource names where to read the token's user PIN from, e.g.
+	// "env:PIN" to read it from the PIN environment variable.
+	PINSource string
+}
+
+// Generate implements KeyStore.
+func (s PKCS11KeyStore) Generate() (crypto.Signer, []byte, error) {
+	modulePath := s.ModulePath
+	if modulePath == "" {
+		modulePath = PKCS11ModulePath
+	}
+
+	ctx, session, err := openPKCS11Session(modulePath, s.TokenLabel, s.PINSource)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	defer closePKCS11Session(ctx, session)
+
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.ObjectLabel),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}), // P-256 OID
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.ObjectLabel),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}
+
+	pubHandle, _, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		pubTemplate, privTemplate)
+	if err != nil {
+		return nil, nil, trace.Wrap(err, "generating key pair on PKCS#11 token")
+	}
+
+	pub, err := pkcs11ECPublicKey(ctx, session, pubHandle)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	signer := &pkcs11Signer{
+		modulePath:  modulePath,
+		tokenLabel:  s.TokenLabel,
+		objectLabel: s.ObjectLabel,
+		pinSource:   s.PINSource,
+		public:      pub,
+	}
+	// The trailing newline keeps the locator on a line of its own when a
+	// formatter appends the certificates right after it (e.g. FormatFile).
+	uri := []byte(fmt.Sprintf("%stoken=%s;object=%s;pin-source=%s\n",
+		pkcs11URIScheme, s.TokenLabel, s.ObjectLabel, s.PINSource))
+	return signer, uri, nil
+}
+
+// Signer resolves this identity's private key into a crypto.Signer. For an
+// ordinary identity file this just parses the embedded PEM key. For one
+// generated with PKCS11KeyStore, id.PrivateKey instead holds a "pkcs11:"
+// locator URI, and the returned Signer talks to the token for every Sign
+// call; the private key itself is never reconstructed in process memory.
+func (id *IdentityFile) Signer() (crypto.Signer, error) {
+	if bytes.HasPrefix(id.PrivateKey, []byte(pkcs11URIScheme)) {
+		return resolvePKCS11Signer(string(id.PrivateKey))
+	}
+	return parsePEMPrivateKey(id.PrivateKey)
+}
+
+// resolvePKCS11Signer parses a "pkcs11:token=...;object=...;pin-source=..."
+// locator and returns a Signer bound to the referenced key.
+func resolvePKCS11Signer(uri string) (crypto.Signer, error) {
+	uri = strings.TrimPrefix(uri, pkcs11URIScheme)
+
+	var tokenLabel, objectLabel, pinSource string
+	for _, part := range strings.Split(uri, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "token":
+			tokenLabel = kv[1]
+		case "object":
+			objectLabel = kv[1]
+		case "pin-source":
+			pinSource = kv[1]
+		}
+	}
+	if tokenLabel == "" || objectLabel == "" {
+		return nil, trace.BadParameter("malformed pkcs11 URI %q", uri)
+	}
+
+	if PKCS11ModulePath == "" {
+		return nil, trace.BadParameter("PKCS11ModulePath is not set; cannot resolve key %q", uri)
+	}
+
+	ctx, session, err := openPKCS11Session(PKCS11ModulePath, tokenLabel, pinSource)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer closePKCS11Session(ctx, session)
+
+	handle, err := pkcs11FindObject(ctx, session, pkcs11.CKO_PUBLIC_KEY, objectLabel)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	pub, err := pkcs11ECPublicKey(ctx, session, handle)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &pkcs11Signer{
+		modulePath:  PKCS11ModulePath,
+		tokenLabel:  tokenLabel,
+		objectLabel: objectLabel,
+		pinSource:   pinSource,
+		public:      pub,
+	}, nil
+}
+
+// pkcs11Signer implements crypto.Signer by opening a fresh session against
+// the token for each Sign call; the private key never leaves the token.
+type pkcs11Signer struct {
+	modulePath  string
+	tokenLabel  string
+	objectLabel string
+	pinSource   string
+	public      *ecdsa.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	ctx, session, err := openPKCS11Session(s.modulePath, s.tokenLabel, s.pinSource)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer closePKCS11Session(ctx, session)
+
+	handle, err := pkcs11FindObject(ctx, session, pkcs11.CKO_PRIVATE_KEY, s.objectLabel)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if err := ctx.SignInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, handle); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	sig, err := ctx.Sign(session, digest)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// CKM_ECDSA yields the raw r||s concatenation; return the ASN.1 DER
+	// form that ecdsa.PrivateKey.Sign produces.
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, trace.BadParameter("unexpected ECDSA signature length %d from token", len(sig))
+	}
+	half := len(sig) / 2
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{
+		new(big.Int).SetBytes(sig[:half]),
+		new(big.Int).SetBytes(sig[half:]),
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return der, nil
+}
+
+// openPKCS11Session loads the module, finds the slot for tokenLabel, opens
+// an RW session, and logs in with the PIN resolved from pinSource.
+func openPKCS11Session(modulePath, tokenLabel, pinSource string) (*pkcs11.Ctx, pkcs11.SessionHandle, error) {
+	if modulePath == "" {
+		return nil, 0, trace.BadParameter("no PKCS#11 module path configured")
+	}
+
+	ctx := pkcs11.New(modulePath)
+	if ctx == nil {
+		return nil, 0, trace.BadParameter("failed to load PKCS#11 module %q", modulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return nil, 0, trace.Wrap(err)
+	}
+
+	slot, err := pkcs11FindSlot(ctx, tokenLabel)
+	if err != nil {
+		ctx.Finalize()
+		return nil, 0, trace.Wrap(err)
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Finalize()
+		return nil, 0, trace.Wrap(err)
+	}
+
+	pin, err := resolvePIN(pinSource)
+	if err != nil {
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		return nil, 0, trace.Wrap(err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		return nil, 0, trace.Wrap(err)
+	}
+
+	return ctx, session, nil
+}
+
+func closePKCS11Session(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) {
+	ctx.Logout(session)
+	ctx.CloseSession(session)
+	ctx.Finalize()
+}
+
+func pkcs11FindSlot(ctx *pkcs11.Ctx, tokenLabel string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, trace.Wrap(err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimRight(info.Label, "\x00 ") == tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, trace.NotFound("no PKCS#11 token found with label %q", tokenLabel)
+}
+
+func pkcs11FindObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, trace.Wrap(err)
+	}
+	defer ctx.FindObjectsFinal(session)
+
+	handles, _, err := ctx.FindObjects(session, 1)
+	if err != nil {
+		return 0, trace.Wrap(err)
+	}
+	if len(handles) == 0 {
+		return 0, trace.NotFound("no object labeled %q found on token", label)
+	}
+	return handles[0], nil
+}
+
+// pkcs11ECPublicKey reads the EC point out of a public key object and
+// reconstructs it as a *ecdsa.PublicKey. Only P-256 keys (as generated by
+// PKCS11KeyStore.Generate) are supported.
+func pkcs11ECPublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle) (*ecdsa.PublicKey, error) {
+	attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(attrs) == 0 || len(attrs[0].Value) < 2 {
+		return nil, trace.BadParameter("token returned no EC point for public key")
+	}
+
+	// The CKA_EC_POINT value is a DER OCTET STRING wrapping the uncompressed
+	// point (0x04 || X || Y); skip the two-byte ASN.1 OCTET STRING header.
+	point := attrs[0].Value[2:]
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, trace.BadParameter("failed to parse EC point from token")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// resolvePIN resolves a pin-source locator (currently only "env:VAR") into
+// the PIN it names.
+func resolvePIN(pinSource string) (string, error) {
+	parts := strings.SplitN(pinSource, ":", 2)
+	if len(parts) != 2 || parts[0] != "env" {
+		return "", trace.BadParameter("unsupported pin-source %q, expected env:VAR", pinSource)
+	}
+	pin := os.Getenv(parts[1])
+	if pin == "" {
+		return "", trace.BadParameter("PIN environment variable %q is not set", parts[1])
+	}
+	return pin, nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gravitational/teleport/lib/client"
+	"github.com/gravitational/trace"
+
+	"golang.org/x/term"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// pkcs12Magic is the first two bytes of a DER-encoded ASN.1 SEQUENCE, which
+// is how every well-formed PKCS#12 file begins. It's not a perfect magic
+// number, but combined with the fact that every other identity file format
+// is plain text, it's enough to distinguish a PKCS#12 file on Decode.
+var pkcs12Magic = []byte{0x30, 0x82}
+
+// isPKCS12 peeks at the head of r without consuming it and reports whether
+// it looks like a DER-encoded PKCS#12 file.
+func isPKCS12(r *bufio.Reader) bool {
+	head, err := r.Peek(len(pkcs12Magic))
+	if err != nil {
+		return false
+	}
+	for i, b := range pkcs12Magic {
+		if head[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePKCS12 bundles key's private key and TLS certificate, along with the
+// supplied CA certificates, into a single password-protected PKCS#12 file.
+func encodePKCS12(key *client.Key, password string, caCertsPEM [][]byte) ([]byte, error) {
+	tlsCert, err := parsePEMCertificate(key.TLSCert)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	signer, err := parsePEMPrivateKey(key.Priv)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var caCerts []*x509.Certificate
+	for _, pemBytes := range caCertsPEM {
+		cert, err := parsePEMCertificate(pemBytes)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		caCerts = append(caCerts, cert)
+	}
+
+	p12Bytes, err := pkcs12.Encode(rand.Reader, signer, tlsCert, caCerts, password)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return p12Bytes, nil
+}
+
+// decodePKCS12 extracts the private key, leaf certificate, and CA
+// certificates out of a password-protected PKCS#12 file, prompting for the
+// password on stdin.
+func decodePKCS12(r *bufio.Reader) (*IdentityFile, error) {
+	der, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	password, err := promptPKCS12Password()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return decodePKCS12WithPassword(der, string(password))
+}
+
+// decodePKCS12WithPassword does the actual PKCS#12 -> IdentityFile
+// conversion given an already-known password; split out from decodePKCS12
+// so it can be exercised without a terminal.
+func decodePKCS12WithPassword(der []byte, password string) (*IdentityFile, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(der, password)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	keyPEM, err := marshalPEMPrivateKey(key)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var ident IdentityFile
+	ident.PrivateKey = keyPEM
+	ident.Certs.TLS = marshalPEMCertificate(cert)
+	for _, ca := range caCerts {
+		ident.CACerts.TLS = append(ident.CACerts.TLS, marshalPEMCertificate(ca))
+	}
+	return &ident, nil
+}
+
+// promptPKCS12Password reads a PKCS#12 passphrase from stdin without
+// echoing it back to the terminal.
+func promptPKCS12Password() ([]byte, error) {
+	fmt.Fprint(os.Stderr, "Enter PKCS#12 password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return password, nil
+}
+
+// parsePEMCertificate decodes a single PEM-encoded certificate.
+func parsePEMCertificate(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, trace.BadParameter("expected a PEM-encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return cert, nil
+}
+
+// parsePEMPrivateKey decodes a single PEM-encoded private key in PKCS#1,
+// PKCS#8, or EC form.
+func parsePEMPrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, trace.BadParameter("expected a PEM-encoded private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, trace.BadParameter("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// marshalPEMCertificate PEM-encodes a certificate.
+func marshalPEMCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// marshalPEMPrivateKey PEM-encodes a private key in PKCS#8 form.
+func marshalPEMPrivateKey(key interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
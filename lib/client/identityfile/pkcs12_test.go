@@ -0,0 +1,90 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gravitational/teleport/lib/client"
+)
+
+func TestPKCS12Roundtrip(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating test certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing test certificate: %v", err)
+	}
+
+	keyPEM, err := marshalPEMPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshaling test key: %v", err)
+	}
+
+	key := &client.Key{
+		Priv:    keyPEM,
+		TLSCert: marshalPEMCertificate(cert),
+	}
+
+	const password = "test-password"
+	p12Bytes, err := encodePKCS12(key, password, nil)
+	if err != nil {
+		t.Fatalf("encodePKCS12: %v", err)
+	}
+
+	ident, err := decodePKCS12WithPassword(p12Bytes, password)
+	if err != nil {
+		t.Fatalf("decodePKCS12WithPassword: %v", err)
+	}
+
+	gotCert, err := ident.TLSCertificate()
+	if err != nil {
+		t.Fatalf("TLSCertificate: %v", err)
+	}
+	if gotCert.Subject.CommonName != "test" {
+		t.Errorf("got CommonName %q, want %q", gotCert.Subject.CommonName, "test")
+	}
+
+	if _, err := ident.Signer(); err != nil {
+		t.Errorf("Signer: %v", err)
+	}
+
+	if _, err := decodePKCS12WithPassword(p12Bytes, "wrong-password"); err == nil {
+		t.Error("decodePKCS12WithPassword succeeded with the wrong password")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"github.com/gravitational/teleport/lib/tlsca"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// TLSCertificate parses and returns the leaf TLS certificate embedded in
+// this identity.
+func (id *IdentityFile) TLSCertificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode(id.Certs.TLS)
+	if block == nil {
+		return nil, trace.BadParameter("identity file does not contain a TLS certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return cert, nil
+}
+
+// SSHCertificate parses and returns the SSH certificate embedded in this
+// identity.
+func (id *IdentityFile) SSHCertificate() (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(id.Certs.SSH)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, trace.BadParameter("identity file does not contain an SSH certificate")
+	}
+	return cert, nil
+}
+
+// Principals returns the valid principals of the embedded SSH certificate,
+// or nil if it can't be parsed.
+func (id *IdentityFile) Principals() []string {
+	cert, err := id.SSHCertificate()
+	if err != nil {
+		return nil
+	}
+	return cert.ValidPrincipals
+}
+
+// Roles returns the Teleport roles encoded into the leaf TLS certificate's
+// subject, or nil if it can't be parsed.
+func (id *IdentityFile) Roles() []string {
+	cert, err := id.TLSCertificate()
+	if err != nil {
+		return nil
+	}
+	identity, err := tlsca.FromSubject(cert.Subject, cert.NotAfter)
+	if err != nil {
+		return nil
+	}
+	return identity.Groups
+}
+
+// NotAfter returns the leaf TLS certificate's expiry, or the zero time if
+// it can't be parsed.
+func (id *IdentityFile) NotAfter() time.Time {
+	cert, err := id.TLSCertificate()
+	if err != nil {
+		return time.Time{}
+	}
+	return cert.NotAfter
+}
+
+// VerifyOptions controls how Verify validates an identity.
+type VerifyOptions struct {
+	// Roots overrides the trusted CA pool used for chain verification. If
+	// nil, the identity's own CACerts.TLS are used.
+	Roots *x509.CertPool
+
+	// CheckRevocation additionally consults the leaf certificate's OCSP
+	// responder and CRL distribution points for revocation status.
+	CheckRevocation bool
+
+	// HTTPClient is used to fetch OCSP responses and CRLs. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (o VerifyOptions) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Verify checks that this identity's leaf TLS certificate chains to a
+// trusted CA and, optionally, has not been revoked. It answers the single
+// question tsh and third-party integrators usually want: is this identity
+// file still valid and unrevoked?
+func (id *IdentityFile) Verify(ctx context.Context, opts VerifyOptions) error {
+	cert, err := id.TLSCertificate()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	roots := opts.Roots
+	if roots == nil {
+		roots = x509.NewCertPool()
+		for _, caPEM := range id.CACerts.TLS {
+			roots.AppendCertsFromPEM(caPEM)
+		}
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{Roots: roots})
+	if err != nil {
+		return trace.Wrap(err, "certificate chain verification failed")
+	}
+
+	if !opts.CheckRevocation {
+		return nil
+	}
+
+	var issuer *x509.Certificate
+	for _, chain := range chains {
+		if len(chain) > 1 {
+			issuer = chain[1]
+			break
+		}
+	}
+	if issuer == nil {
+		return trace.BadParameter("could not determine issuer for revocation check")
+	}
+
+	revoked, checked, err := checkOCSP(ctx, opts.httpClient(), cert, issuer)
+	if err != nil || !checked {
+		var crlErr error
+		revoked, crlErr = checkCRL(ctx, opts.httpClient(), cert, issuer)
+		if crlErr != nil {
+			if err != nil {
+				return trace.Wrap(err, "OCSP check failed")
+			}
+			return trace.Wrap(crlErr, "CRL check failed")
+		}
+	}
+	if revoked {
+		return trace.AccessDenied("certificate %s has been revoked", cert.SerialNumber)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// caLeafPair generates a self-signed CA and a leaf certificate it signs,
+// returning their PEM encodings.
+func caLeafPair(t *testing.T, leafNotAfter time.Time) (caPEM, leafPEM []byte) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     leafNotAfter,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating leaf certificate: %v", err)
+	}
+	leafCert, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("parsing leaf certificate: %v", err)
+	}
+
+	return marshalPEMCertificate(caCert), marshalPEMCertificate(leafCert)
+}
+
+func TestVerifySucceedsForValidChain(t *testing.T) {
+	caPEM, leafPEM := caLeafPair(t, time.Now().Add(time.Hour))
+
+	ident := &IdentityFile{}
+	ident.Certs.TLS = leafPEM
+	ident.CACerts.TLS = [][]byte{caPEM}
+
+	if err := ident.Verify(context.Background(), VerifyOptions{}); err != nil {
+		t.Errorf("Verify() = %v, want nil for a valid chain", err)
+	}
+}
+
+func TestVerifyFailsWithoutTrustedCA(t *testing.T) {
+	_, leafPEM := caLeafPair(t, time.Now().Add(time.Hour))
+
+	ident := &IdentityFile{}
+	ident.Certs.TLS = leafPEM
+	// CACerts.TLS deliberately left empty: no trusted root to chain to.
+
+	if err := ident.Verify(context.Background(), VerifyOptions{}); err == nil {
+		t.Error("Verify() = nil, want an error when no trusted CA is available")
+	}
+}
+
+func TestVerifyFailsForExpiredLeaf(t *testing.T) {
+	caPEM, leafPEM := caLeafPair(t, time.Now().Add(-time.Hour))
+
+	ident := &IdentityFile{}
+	ident.Certs.TLS = leafPEM
+	ident.CACerts.TLS = [][]byte{caPEM}
+
+	if err := ident.Verify(context.Background(), VerifyOptions{}); err == nil {
+		t.Error("Verify() = nil, want an error for an expired leaf certificate")
+	}
+}
+
+func TestVerifyFailsAgainstUnrelatedCA(t *testing.T) {
+	_, leafPEM := caLeafPair(t, time.Now().Add(time.Hour))
+	unrelatedCAPEM, _ := caLeafPair(t, time.Now().Add(time.Hour))
+
+	ident := &IdentityFile{}
+	ident.Certs.TLS = leafPEM
+	ident.CACerts.TLS = [][]byte{unrelatedCAPEM}
+
+	if err := ident.Verify(context.Background(), VerifyOptions{}); err == nil {
+		t.Error("Verify() = nil, want an error when the leaf doesn't chain to the given CA")
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identityfile
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/gravitational/trace"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher watches one or more identity files on disk (as produced by Write)
+// and re-decodes them whenever their contents change, so that long-running
+// clients built on FormatTLS (or any other format) can pick up rotated,
+// short-lived Teleport certs without restarting.
+type Watcher struct {
+	format  Format
+	paths   []string
+	fw      *fsnotify.Watcher
+	updates chan *IdentityFile
+	errors  chan error
+	closeCh chan struct{}
+
+	current atomic.Value // holds *IdentityFile
+}
+
+// NewWatcher starts watching the given identity file paths, decoding them
+// with the Formatter registered for format (see DecodeFormat) so that
+// third-party formats' own Decode logic is honored. paths is typically the
+// filesWritten slice returned by Write: a single file for
+// FormatFile/FormatPKCS12, or multiple for FormatOpenSSH/FormatTLS. The
+// files are decoded once immediately so Updates() has a value to read
+// right away, then again on every subsequent change.
+func NewWatcher(format Format, paths ...string) (*Watcher, error) {
+	if len(paths) == 0 {
+		return nil, trace.BadParameter("at least one path is required")
+	}
+	if _, ok := Lookup(format); !ok {
+		return nil, trace.BadParameter("unsupported identity format: %q, use one of %q", format, registeredFormats())
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, path := range paths {
+		if err := fw.Add(path); err != nil {
+			fw.Close()
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	w := &Watcher{
+		format:  format,
+		paths:   paths,
+		fw:      fw,
+		updates: make(chan *IdentityFile, 1),
+		errors:  make(chan error, 1),
+		closeCh: make(chan struct{}),
+	}
+
+	if err := w.reload(); err != nil {
+		fw.Close()
+		return nil, trace.Wrap(err)
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Updates returns the channel on which re-decoded identity files are
+// delivered. The most recently decoded IdentityFile is also available
+// synchronously via Current.
+func (w *Watcher) Updates() <-chan *IdentityFile {
+	return w.updates
+}
+
+// Errors returns the channel on which watch and decode errors are
+// delivered.
+func (w *Watcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Current returns the most recently decoded identity file.
+func (w *Watcher) Current() *IdentityFile {
+	ident, _ := w.current.Load().(*IdentityFile)
+	return ident
+}
+
+// Close stops the watcher and releases its underlying file descriptors.
+func (w *Watcher) Close() error {
+	close(w.closeCh)
+	return w.fw.Close()
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case event, ok := <-w.fw.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := w.reload(); err != nil {
+				w.sendError(err)
+			}
+		case err, ok := <-w.fw.Errors:
+			if !ok {
+				return
+			}
+			w.sendError(err)
+		}
+	}
+}
+
+// reload re-reads and re-decodes all watched paths and, on success, stores
+// the result as Current and delivers it on Updates.
+func (w *Watcher) reload() error {
+	var combined []byte
+	for _, path := range w.paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		combined = append(combined, data...)
+	}
+
+	ident, err := DecodeFormat(w.format, bytes.NewReader(combined))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	w.current.Store(ident)
+	select {
+	case w.updates <- ident:
+	default:
+		// Drain a stale pending update so the channel always holds the
+		// most recent identity file rather than blocking the reloader.
+		select {
+		case <-w.updates:
+		default:
+		}
+		w.updates <- ident
+	}
+	return nil
+}
+
+func (w *Watcher) sendError(err error) {
+	select {
+	case w.errors <- trace.Wrap(err):
+	default:
+	}
+}
+
+// TLSConfig returns a *tls.Config whose GetCertificate and
+// GetClientCertificate callbacks always return the most recently loaded
+// identity, so a single long-lived tls.Config can transparently ride out
+// cert rotation performed by this Watcher.
+func (w *Watcher) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return w.tlsCertificate()
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return w.tlsCertificate()
+		},
+	}
+}
+
+func (w *Watcher) tlsCertificate() (*tls.Certificate, error) {
+	ident := w.Current()
+	if ident == nil {
+		return nil, trace.NotFound("no identity file has been loaded yet")
+	}
+	cert, err := tls.X509KeyPair(ident.Certs.TLS, ident.PrivateKey)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &cert, nil
+}